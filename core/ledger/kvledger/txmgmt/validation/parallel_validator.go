@@ -0,0 +1,227 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package validation
+
+import (
+	"sync"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric/core/ledger/internal/version"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/privacyenabledstate"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/statedb"
+)
+
+// ParallelValidator validates the transactions of a block across a pool of worker goroutines
+type ParallelValidator struct {
+	db      privacyenabledstate.DB
+	workers int
+}
+
+// NewParallelValidator constructs a ParallelValidator that validates across workers goroutines
+func NewParallelValidator(db privacyenabledstate.DB, workers int) *ParallelValidator {
+	if workers < 1 {
+		workers = 1
+	}
+	return &ParallelValidator{
+		db:      db,
+		workers: workers,
+	}
+}
+
+// readsetVersionCache holds the committed versions of every key and key-hash read by a block
+type readsetVersionCache struct {
+	public map[statedb.CompositeKey]*version.Height
+	hashed map[privacyenabledstate.HashedCompositeKey]*version.Height
+}
+
+// ValidateAndPrepareBatch validates block's transactions and returns the resulting PubAndHashUpdates
+// along with the validation code assigned to each transaction, in block order
+func (v *ParallelValidator) ValidateAndPrepareBatch(block *Block) (*PubAndHashUpdates, []peer.TxValidationCode, error) {
+	cache, err := v.preloadReadsetVersions(block)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	codes := v.validateAgainstCommitted(block, cache)
+
+	return v.applyInBlockOrder(block, codes)
+}
+
+// preloadReadsetVersions bulk-loads the committed versions of every key read by block
+func (v *ParallelValidator) preloadReadsetVersions(block *Block) (*readsetVersionCache, error) {
+	pubKeys := map[string][]string{}
+	pubSeen := map[statedb.CompositeKey]bool{}
+	hashedKeys := map[string]map[string][][]byte{}
+	hashedSeen := map[privacyenabledstate.HashedCompositeKey]bool{}
+
+	for _, tx := range block.Txs {
+		if tx.RWSet == nil {
+			continue
+		}
+		for _, nsRWSet := range tx.RWSet.NsRwSets {
+			ns := nsRWSet.NameSpace
+			if nsRWSet.KvRwSet != nil {
+				for _, r := range nsRWSet.KvRwSet.Reads {
+					ck := statedb.CompositeKey{Namespace: ns, Key: r.Key}
+					if pubSeen[ck] {
+						continue
+					}
+					pubSeen[ck] = true
+					pubKeys[ns] = append(pubKeys[ns], r.Key)
+				}
+			}
+			for _, collHashedRWSet := range nsRWSet.CollHashedRwSets {
+				if collHashedRWSet.HashedRwSet == nil {
+					continue
+				}
+				coll := collHashedRWSet.CollectionName
+				for _, r := range collHashedRWSet.HashedRwSet.HashedReads {
+					hck := privacyenabledstate.HashedCompositeKey{Namespace: ns, CollectionName: coll, KeyHash: string(r.KeyHash)}
+					if hashedSeen[hck] {
+						continue
+					}
+					hashedSeen[hck] = true
+					if hashedKeys[ns] == nil {
+						hashedKeys[ns] = map[string][][]byte{}
+					}
+					hashedKeys[ns][coll] = append(hashedKeys[ns][coll], r.KeyHash)
+				}
+			}
+		}
+	}
+
+	pubVersions, err := v.db.GetVersions(pubKeys)
+	if err != nil {
+		return nil, err
+	}
+	hashedVersions, err := v.db.GetVersionsHashed(hashedKeys)
+	if err != nil {
+		return nil, err
+	}
+	return &readsetVersionCache{public: pubVersions, hashed: hashedVersions}, nil
+}
+
+// validateAgainstCommitted validates each transaction of block concurrently against cache
+func (v *ParallelValidator) validateAgainstCommitted(block *Block, cache *readsetVersionCache) []peer.TxValidationCode {
+	codes := make([]peer.TxValidationCode, len(block.Txs))
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < v.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range work {
+				tx := block.Txs[idx]
+				if tx.ValidationCode != peer.TxValidationCode_VALID {
+					codes[idx] = tx.ValidationCode
+					continue
+				}
+				codes[idx] = validateReadSetAgainstCache(tx, cache)
+			}
+		}()
+	}
+	for idx := range block.Txs {
+		work <- idx
+	}
+	close(work)
+	wg.Wait()
+
+	return codes
+}
+
+// validateReadSetAgainstCache validates tx's read-set against cache
+func validateReadSetAgainstCache(tx *Transaction, cache *readsetVersionCache) peer.TxValidationCode {
+	if tx.RWSet == nil {
+		return peer.TxValidationCode_VALID
+	}
+	for _, nsRWSet := range tx.RWSet.NsRwSets {
+		ns := nsRWSet.NameSpace
+		if nsRWSet.KvRwSet != nil {
+			for _, r := range nsRWSet.KvRwSet.Reads {
+				committed := cache.public[statedb.CompositeKey{Namespace: ns, Key: r.Key}]
+				if !sameVersion(r.Version, committed) {
+					return peer.TxValidationCode_MVCC_READ_CONFLICT
+				}
+			}
+		}
+		for _, collHashedRWSet := range nsRWSet.CollHashedRwSets {
+			if collHashedRWSet.HashedRwSet == nil {
+				continue
+			}
+			coll := collHashedRWSet.CollectionName
+			for _, r := range collHashedRWSet.HashedRwSet.HashedReads {
+				hck := privacyenabledstate.HashedCompositeKey{Namespace: ns, CollectionName: coll, KeyHash: string(r.KeyHash)}
+				committed := cache.hashed[hck]
+				if !sameVersion(r.Version, committed) {
+					return peer.TxValidationCode_MVCC_READ_CONFLICT
+				}
+			}
+		}
+	}
+	return peer.TxValidationCode_VALID
+}
+
+// applyInBlockOrder re-validates and applies block's transactions in order into a pending PubAndHashUpdates
+func (v *ParallelValidator) applyInBlockOrder(block *Block, codes []peer.TxValidationCode) (*PubAndHashUpdates, []peer.TxValidationCode, error) {
+	pending := NewPubAndHashUpdates()
+	for idx, tx := range block.Txs {
+		if codes[idx] != peer.TxValidationCode_VALID {
+			continue
+		}
+		if conflictsWithPendingUpdates(tx, pending) {
+			codes[idx] = peer.TxValidationCode_MVCC_READ_CONFLICT
+			continue
+		}
+		txHeight := version.NewHeight(block.Num, uint64(idx))
+		if err := pending.ApplyWriteSet(tx.RWSet, txHeight, v.db, tx.ContainsPostOrderWrites); err != nil {
+			return nil, nil, err
+		}
+	}
+	return pending, codes, nil
+}
+
+// conflictsWithPendingUpdates reports whether tx reads a key or key-hash already present in pending
+func conflictsWithPendingUpdates(tx *Transaction, pending *PubAndHashUpdates) bool {
+	if tx.RWSet == nil {
+		return false
+	}
+	for _, nsRWSet := range tx.RWSet.NsRwSets {
+		ns := nsRWSet.NameSpace
+		if nsRWSet.KvRwSet != nil {
+			for _, r := range nsRWSet.KvRwSet.Reads {
+				if _, ok := pending.PubUpdates.Get(ns, r.Key); ok {
+					return true
+				}
+			}
+		}
+		for _, collHashedRWSet := range nsRWSet.CollHashedRwSets {
+			if collHashedRWSet.HashedRwSet == nil {
+				continue
+			}
+			coll := collHashedRWSet.CollectionName
+			for _, r := range collHashedRWSet.HashedRwSet.HashedReads {
+				if _, ok := pending.HashUpdates.Get(ns, coll, r.KeyHash); ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// sameVersion reports whether read matches committed
+func sameVersion(read *kvrwset.Version, committed *version.Height) bool {
+	if read == nil {
+		return committed == nil
+	}
+	if committed == nil {
+		return false
+	}
+	return version.NewHeight(read.BlockNum, read.TxNum).Compare(committed) == 0
+}
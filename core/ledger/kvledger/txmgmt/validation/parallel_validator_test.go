@@ -0,0 +1,237 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package validation
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric/core/ledger/internal/version"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/privacyenabledstate"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/statedb"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeVersionDB embeds privacyenabledstate.DB so that it satisfies the full
+// interface while only overriding the bulk version lookups and the
+// committed-metadata lookup (made by ApplyWriteSet for value-only writes)
+// the ParallelValidator relies on.
+type fakeVersionDB struct {
+	privacyenabledstate.DB
+}
+
+func (d *fakeVersionDB) GetVersions(nsKeys map[string][]string) (map[statedb.CompositeKey]*version.Height, error) {
+	versions := map[statedb.CompositeKey]*version.Height{}
+	for ns, keys := range nsKeys {
+		for _, key := range keys {
+			versions[statedb.CompositeKey{Namespace: ns, Key: key}] = version.NewHeight(1, 1)
+		}
+	}
+	return versions, nil
+}
+
+func (d *fakeVersionDB) GetVersionsHashed(nsCollKeyHashes map[string]map[string][][]byte) (map[privacyenabledstate.HashedCompositeKey]*version.Height, error) {
+	versions := map[privacyenabledstate.HashedCompositeKey]*version.Height{}
+	for ns, collKeyHashes := range nsCollKeyHashes {
+		for coll, keyHashes := range collKeyHashes {
+			for _, keyHash := range keyHashes {
+				hck := privacyenabledstate.HashedCompositeKey{Namespace: ns, CollectionName: coll, KeyHash: string(keyHash)}
+				versions[hck] = version.NewHeight(1, 1)
+			}
+		}
+	}
+	return versions, nil
+}
+
+func (d *fakeVersionDB) GetStateMetadata(ns, key string) ([]byte, error) {
+	return nil, nil
+}
+
+func (d *fakeVersionDB) ApplyPrivacyAwareUpdates(*privacyenabledstate.UpdateBatch, *version.Height) error {
+	return nil
+}
+
+// independentTxsBlock builds a block of numTxs transactions, each of which
+// reads and writes its own, disjoint key in namespace "ns1" - the best case
+// for parallel validation, since no transaction conflicts with another.
+func independentTxsBlock(numTxs int) *Block {
+	block := &Block{Num: 1, Txs: make([]*Transaction, numTxs)}
+	for i := 0; i < numTxs; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		rwSet := &rwsetutil.TxRwSet{
+			NsRwSets: []*rwsetutil.NsRwSet{
+				{
+					NameSpace: "ns1",
+					KvRwSet: &kvrwset.KVRWSet{
+						Reads: []*kvrwset.KVRead{
+							{Key: key, Version: &kvrwset.Version{BlockNum: 1, TxNum: 1}},
+						},
+						Writes: []*kvrwset.KVWrite{
+							{Key: key, Value: []byte("value")},
+						},
+					},
+				},
+			},
+		}
+		block.Txs[i] = &Transaction{
+			IndexInBlock: i,
+			ID:           fmt.Sprintf("tx-%d", i),
+			RWSet:        rwSet,
+		}
+	}
+	return block
+}
+
+func benchmarkParallelValidator(b *testing.B, numTxs, workers int) {
+	db := &fakeVersionDB{}
+	validator := NewParallelValidator(db, workers)
+	block := independentTxsBlock(numTxs)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := validator.ValidateAndPrepareBatch(block); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParallelValidator_1Worker_500Txs(b *testing.B)  { benchmarkParallelValidator(b, 500, 1) }
+func BenchmarkParallelValidator_4Workers_500Txs(b *testing.B) { benchmarkParallelValidator(b, 500, 4) }
+func BenchmarkParallelValidator_8Workers_500Txs(b *testing.B) { benchmarkParallelValidator(b, 500, 8) }
+
+func txReadingKey(ns, key string, readAt *kvrwset.Version) *Transaction {
+	return &Transaction{
+		RWSet: &rwsetutil.TxRwSet{
+			NsRwSets: []*rwsetutil.NsRwSet{
+				{
+					NameSpace: ns,
+					KvRwSet: &kvrwset.KVRWSet{
+						Reads: []*kvrwset.KVRead{{Key: key, Version: readAt}},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestValidateReadSetAgainstCacheDetectsStaleRead(t *testing.T) {
+	cache := &readsetVersionCache{
+		public: map[statedb.CompositeKey]*version.Height{
+			{Namespace: "ns1", Key: "key1"}: version.NewHeight(1, 5),
+		},
+	}
+	tx := txReadingKey("ns1", "key1", &kvrwset.Version{BlockNum: 1, TxNum: 1})
+
+	require.Equal(t, peer.TxValidationCode_MVCC_READ_CONFLICT, validateReadSetAgainstCache(tx, cache))
+}
+
+func TestValidateReadSetAgainstCacheAcceptsMatchingRead(t *testing.T) {
+	cache := &readsetVersionCache{
+		public: map[statedb.CompositeKey]*version.Height{
+			{Namespace: "ns1", Key: "key1"}: version.NewHeight(1, 1),
+		},
+	}
+	tx := txReadingKey("ns1", "key1", &kvrwset.Version{BlockNum: 1, TxNum: 1})
+
+	require.Equal(t, peer.TxValidationCode_VALID, validateReadSetAgainstCache(tx, cache))
+}
+
+func TestConflictsWithPendingUpdatesDetectsWriteByPrecedingTxOfSameBlock(t *testing.T) {
+	pending := NewPubAndHashUpdates()
+	pending.PubUpdates.PutValAndMetadata("ns1", "key1", []byte("tx0-value"), nil, version.NewHeight(1, 0))
+
+	tx := txReadingKey("ns1", "key1", &kvrwset.Version{BlockNum: 1, TxNum: 1})
+
+	require.True(t, conflictsWithPendingUpdates(tx, pending))
+}
+
+func TestConflictsWithPendingUpdatesIgnoresUnrelatedKeys(t *testing.T) {
+	pending := NewPubAndHashUpdates()
+	pending.PubUpdates.PutValAndMetadata("ns1", "key1", []byte("tx0-value"), nil, version.NewHeight(1, 0))
+
+	tx := txReadingKey("ns1", "key2", &kvrwset.Version{BlockNum: 1, TxNum: 1})
+
+	require.False(t, conflictsWithPendingUpdates(tx, pending))
+}
+
+// TestValidateAndPrepareBatchAppliesValidTxsInBlockOrder exercises the full
+// ValidateAndPrepareBatch path: tx0 and tx1 both read key1 at the version the
+// db reports as committed, so the concurrent, committed-state-only pass
+// deems both VALID; but tx0 also writes key1 and precedes tx1 in the block,
+// so the serial pass must flag tx1 as an MVCC conflict and must not let its
+// (nonexistent) write clobber tx0's, which should be the only write visible
+// in the returned updates.
+func TestValidateAndPrepareBatchAppliesValidTxsInBlockOrder(t *testing.T) {
+	db := &fakeVersionDB{}
+	readAt := &kvrwset.Version{BlockNum: 1, TxNum: 1}
+
+	tx0 := &Transaction{
+		ID: "tx0",
+		RWSet: &rwsetutil.TxRwSet{
+			NsRwSets: []*rwsetutil.NsRwSet{
+				{
+					NameSpace: "ns1",
+					KvRwSet: &kvrwset.KVRWSet{
+						Reads:  []*kvrwset.KVRead{{Key: "key1", Version: readAt}},
+						Writes: []*kvrwset.KVWrite{{Key: "key1", Value: []byte("tx0-value")}},
+					},
+				},
+			},
+		},
+	}
+	tx1 := txReadingKey("ns1", "key1", readAt)
+	tx1.ID = "tx1"
+
+	block := &Block{Num: 1, Txs: []*Transaction{tx0, tx1}}
+	validator := NewParallelValidator(db, 4)
+
+	updates, codes, err := validator.ValidateAndPrepareBatch(block)
+	require.NoError(t, err)
+	require.Equal(t, []peer.TxValidationCode{peer.TxValidationCode_VALID, peer.TxValidationCode_MVCC_READ_CONFLICT}, codes)
+
+	vv, ok := updates.PubUpdates.Get("ns1", "key1")
+	require.True(t, ok)
+	require.Equal(t, []byte("tx0-value"), vv.Value)
+}
+
+// TestValidateAndPrepareBatchPreservesPreExistingInvalidCode ensures a
+// transaction already marked invalid upstream (e.g. an endorsement policy
+// failure) is neither re-validated to VALID nor has its write-set applied,
+// even though its read-set matches committed state.
+func TestValidateAndPrepareBatchPreservesPreExistingInvalidCode(t *testing.T) {
+	db := &fakeVersionDB{}
+	readAt := &kvrwset.Version{BlockNum: 1, TxNum: 1}
+
+	tx := &Transaction{
+		ID:             "tx0",
+		ValidationCode: peer.TxValidationCode_ENDORSEMENT_POLICY_FAILURE,
+		RWSet: &rwsetutil.TxRwSet{
+			NsRwSets: []*rwsetutil.NsRwSet{
+				{
+					NameSpace: "ns1",
+					KvRwSet: &kvrwset.KVRWSet{
+						Reads:  []*kvrwset.KVRead{{Key: "key1", Version: readAt}},
+						Writes: []*kvrwset.KVWrite{{Key: "key1", Value: []byte("tx0-value")}},
+					},
+				},
+			},
+		},
+	}
+
+	block := &Block{Num: 1, Txs: []*Transaction{tx}}
+	validator := NewParallelValidator(db, 4)
+
+	updates, codes, err := validator.ValidateAndPrepareBatch(block)
+	require.NoError(t, err)
+	require.Equal(t, []peer.TxValidationCode{peer.TxValidationCode_ENDORSEMENT_POLICY_FAILURE}, codes)
+
+	_, ok := updates.PubUpdates.Get("ns1", "key1")
+	require.False(t, ok, "a transaction already marked invalid upstream must not have its write-set applied")
+}
@@ -0,0 +1,68 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package validation
+
+import (
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+	"github.com/pkg/errors"
+)
+
+// PostOrderSimulatorProvider supplies a TxSimulator for non-endorser transactions (e.g. config transactions)
+type PostOrderSimulatorProvider interface {
+	NewTxSimulator(txid string) (ledger.TxSimulator, error)
+}
+
+// PreparerConfig configures how the batch preparer turns a block's raw transactions into Transaction values
+type PreparerConfig struct {
+	// PostOrderSimulatorProvider may be nil on channels that never carry non-endorser transactions
+	PostOrderSimulatorProvider PostOrderSimulatorProvider
+}
+
+// PreparePostOrderTransaction synthesizes a Transaction for a non-endorser transaction by simulating it
+func PreparePostOrderTransaction(
+	indexInBlock int,
+	txid string,
+	cfg *PreparerConfig,
+	simulate func(simulator ledger.TxSimulator) error,
+) (*Transaction, error) {
+	if cfg == nil || cfg.PostOrderSimulatorProvider == nil {
+		return nil, errors.Errorf("no post-order simulator provider configured to prepare non-endorser transaction [%s]", txid)
+	}
+
+	simulator, err := cfg.PostOrderSimulatorProvider.NewTxSimulator(txid)
+	if err != nil {
+		return nil, err
+	}
+	defer simulator.Done()
+
+	if err := simulate(simulator); err != nil {
+		return nil, err
+	}
+
+	simRes, err := simulator.GetTxSimulationResults()
+	if err != nil {
+		return nil, err
+	}
+	pubRWSetBytes, err := simRes.GetPubSimulationBytes()
+	if err != nil {
+		return nil, err
+	}
+	txRWSet := &rwsetutil.TxRwSet{}
+	if err := txRWSet.FromProtoBytes(pubRWSetBytes); err != nil {
+		return nil, err
+	}
+
+	return &Transaction{
+		IndexInBlock:            indexInBlock,
+		ID:                      txid,
+		RWSet:                   txRWSet,
+		ValidationCode:          peer.TxValidationCode_VALID,
+		ContainsPostOrderWrites: true,
+	}, nil
+}
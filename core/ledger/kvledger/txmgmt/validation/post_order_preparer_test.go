@@ -0,0 +1,91 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package validation
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTxSimulator embeds ledger.TxSimulator so that it satisfies the full
+// interface while only overriding the methods PreparePostOrderTransaction
+// actually calls.
+type fakeTxSimulator struct {
+	ledger.TxSimulator
+
+	simResults *ledger.TxSimulationResults
+	simResErr  error
+	doneCalled bool
+}
+
+func (s *fakeTxSimulator) GetTxSimulationResults() (*ledger.TxSimulationResults, error) {
+	return s.simResults, s.simResErr
+}
+
+func (s *fakeTxSimulator) Done() {
+	s.doneCalled = true
+}
+
+type fakePostOrderSimulatorProvider struct {
+	simulator *fakeTxSimulator
+	err       error
+}
+
+func (p *fakePostOrderSimulatorProvider) NewTxSimulator(txid string) (ledger.TxSimulator, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	return p.simulator, nil
+}
+
+func TestPreparePostOrderTransactionErrorsWithNoProviderConfigured(t *testing.T) {
+	_, err := PreparePostOrderTransaction(0, "tx1", nil, func(ledger.TxSimulator) error { return nil })
+	require.Error(t, err)
+
+	_, err = PreparePostOrderTransaction(0, "tx1", &PreparerConfig{}, func(ledger.TxSimulator) error { return nil })
+	require.Error(t, err)
+}
+
+func TestPreparePostOrderTransactionPropagatesSimulatorProviderError(t *testing.T) {
+	cfg := &PreparerConfig{PostOrderSimulatorProvider: &fakePostOrderSimulatorProvider{err: errors.New("no channel resources")}}
+
+	_, err := PreparePostOrderTransaction(0, "tx1", cfg, func(ledger.TxSimulator) error { return nil })
+	require.EqualError(t, err, "no channel resources")
+}
+
+func TestPreparePostOrderTransactionPropagatesSimulateError(t *testing.T) {
+	sim := &fakeTxSimulator{}
+	cfg := &PreparerConfig{PostOrderSimulatorProvider: &fakePostOrderSimulatorProvider{simulator: sim}}
+
+	_, err := PreparePostOrderTransaction(0, "tx1", cfg, func(ledger.TxSimulator) error { return errors.New("apply config failed") })
+	require.EqualError(t, err, "apply config failed")
+	require.True(t, sim.doneCalled, "the simulator must be released even when simulate fails")
+}
+
+func TestPreparePostOrderTransactionHappyPathSetsContainsPostOrderWrites(t *testing.T) {
+	pubRWSet := &rwset.TxReadWriteSet{DataModel: rwset.TxReadWriteSet_KV}
+	sim := &fakeTxSimulator{simResults: &ledger.TxSimulationResults{PubSimulationResults: pubRWSet}}
+	cfg := &PreparerConfig{PostOrderSimulatorProvider: &fakePostOrderSimulatorProvider{simulator: sim}}
+
+	var simulatedWith ledger.TxSimulator
+	tx, err := PreparePostOrderTransaction(3, "tx1", cfg, func(simulator ledger.TxSimulator) error {
+		simulatedWith = simulator
+		return nil
+	})
+
+	require.NoError(t, err)
+	require.Same(t, sim, simulatedWith)
+	require.Equal(t, 3, tx.IndexInBlock)
+	require.Equal(t, "tx1", tx.ID)
+	require.NotNil(t, tx.RWSet)
+	require.True(t, tx.ContainsPostOrderWrites)
+	require.True(t, sim.doneCalled)
+}
@@ -7,11 +7,18 @@ SPDX-License-Identifier: Apache-2.0
 package validation
 
 import (
+	"bytes"
+	"fmt"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
 	"github.com/hyperledger/fabric-protos-go/peer"
 	"github.com/hyperledger/fabric/common/flogging"
+	"github.com/hyperledger/fabric/common/util"
 	"github.com/hyperledger/fabric/core/ledger/internal/version"
 	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/privacyenabledstate"
 	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/statedb"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/statedb/statemetadata"
 )
 
 var logger = flogging.MustGetLogger("validation")
@@ -44,6 +51,12 @@ type PubAndHashUpdates struct {
 // does not match with the corresponding pvt data  supplied with the block for validation
 type ErrPvtdataHashMissmatch struct {
 	Msg string
+
+	Namespace    string
+	Collection   string
+	TxID         string
+	ExpectedHash []byte
+	ActualHash   []byte
 }
 
 func (e *ErrPvtdataHashMissmatch) Error() string {
@@ -53,8 +66,8 @@ func (e *ErrPvtdataHashMissmatch) Error() string {
 // NewPubAndHashUpdates constructs an empty PubAndHashUpdates
 func NewPubAndHashUpdates() *PubAndHashUpdates {
 	return &PubAndHashUpdates{
-		privacyenabledstate.NewPubUpdateBatch(),
-		privacyenabledstate.NewHashedUpdateBatch(),
+		PubUpdates:  privacyenabledstate.NewPubUpdateBatch(),
+		HashUpdates: privacyenabledstate.NewHashedUpdateBatch(),
 	}
 }
 
@@ -90,6 +103,47 @@ func (t *Transaction) RetrieveHash(ns string, coll string) []byte {
 	return nil
 }
 
+// Validate checks pvtdata against the collection hashes recorded in b's transactions, using hashFunc
+func (b *Block) Validate(pvtdata map[string]map[string][]byte, hashFunc rwsetutil.HashFunc) error {
+	if hashFunc == nil {
+		hashFunc = util.ComputeSHA256
+	}
+	for _, tx := range b.Txs {
+		if tx.RWSet == nil {
+			continue
+		}
+		for _, nsRWSet := range tx.RWSet.NsRwSets {
+			ns := nsRWSet.NameSpace
+			for _, collRWSet := range nsRWSet.CollHashedRwSets {
+				coll := collRWSet.CollectionName
+				expectedHash := tx.RetrieveHash(ns, coll)
+				if expectedHash == nil {
+					continue
+				}
+				pvtBytes, ok := pvtdata[ns][coll]
+				if !ok {
+					continue
+				}
+				actualHash := hashFunc(pvtBytes)
+				if !bytes.Equal(expectedHash, actualHash) {
+					return &ErrPvtdataHashMissmatch{
+						Msg: fmt.Sprintf(
+							"hash mismatch for collection [%s] of namespace [%s] in tx [%s]: expected [%x], got [%x]",
+							coll, ns, tx.ID, expectedHash, actualHash,
+						),
+						Namespace:    ns,
+						Collection:   coll,
+						TxID:         tx.ID,
+						ExpectedHash: expectedHash,
+						ActualHash:   actualHash,
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
 // ApplyWriteSet adds (or deletes) the key/values present in the write set to the PubAndHashUpdates
 func (u *PubAndHashUpdates) ApplyWriteSet(
 	txRWSet *rwsetutil.TxRwSet,
@@ -123,3 +177,171 @@ func (u *PubAndHashUpdates) ApplyWriteSet(
 	}
 	return nil
 }
+
+// compositeKey identifies a key across public and private-data-hash writes; coll is empty for public writes
+type compositeKey struct {
+	ns, coll, key string
+}
+
+// keyOps accumulates the value, metadata, and delete status a transaction writes to a single key
+type keyOps struct {
+	value       []byte
+	metadata    []byte
+	valueSet    bool
+	metadataSet bool
+	deleted     bool
+}
+
+func (ko *keyOps) isDelete() bool {
+	return ko.deleted
+}
+
+// prepareTxOps resolves txRWSet's writes into a fully-populated keyOps per key, consulting precedingUpdates before db
+func prepareTxOps(
+	txRWSet *rwsetutil.TxRwSet,
+	txHeight *version.Height,
+	precedingUpdates *PubAndHashUpdates,
+	db privacyenabledstate.DB,
+) (map[compositeKey]*keyOps, error) {
+	txops := map[compositeKey]*keyOps{}
+
+	getOrCreate := func(k compositeKey) *keyOps {
+		ops, ok := txops[k]
+		if !ok {
+			ops = &keyOps{}
+			txops[k] = ops
+		}
+		return ops
+	}
+
+	for _, nsRWSet := range txRWSet.NsRwSets {
+		ns := nsRWSet.NameSpace
+		if nsRWSet.KvRwSet != nil {
+			for _, kvWrite := range nsRWSet.KvRwSet.Writes {
+				ops := getOrCreate(compositeKey{ns: ns, key: kvWrite.Key})
+				ops.deleted = kvWrite.IsDelete
+				ops.valueSet = true
+				ops.value = kvWrite.Value
+			}
+			for _, metadataWrite := range nsRWSet.KvRwSet.MetadataWrites {
+				metadataBytes, err := serializeMetadataEntries(metadataWrite.Entries)
+				if err != nil {
+					return nil, err
+				}
+				ops := getOrCreate(compositeKey{ns: ns, key: metadataWrite.Key})
+				ops.metadataSet = true
+				ops.metadata = metadataBytes
+			}
+		}
+		for _, collHashedRWSet := range nsRWSet.CollHashedRwSets {
+			if collHashedRWSet.HashedRwSet == nil {
+				continue
+			}
+			coll := collHashedRWSet.CollectionName
+			for _, hashedWrite := range collHashedRWSet.HashedRwSet.HashedWrites {
+				ops := getOrCreate(compositeKey{ns: ns, coll: coll, key: string(hashedWrite.KeyHash)})
+				ops.deleted = hashedWrite.IsDelete
+				ops.valueSet = true
+				ops.value = hashedWrite.ValueHash
+			}
+			for _, metadataWrite := range collHashedRWSet.HashedRwSet.MetadataWrites {
+				metadataBytes, err := serializeMetadataEntries(metadataWrite.Entries)
+				if err != nil {
+					return nil, err
+				}
+				ops := getOrCreate(compositeKey{ns: ns, coll: coll, key: string(metadataWrite.KeyHash)})
+				ops.metadataSet = true
+				ops.metadata = metadataBytes
+			}
+		}
+	}
+
+	for k, ops := range txops {
+		if ops.deleted {
+			continue
+		}
+		switch {
+		case ops.valueSet && !ops.metadataSet:
+			metadata, err := resolveMetadata(k, precedingUpdates, db)
+			if err != nil {
+				return nil, err
+			}
+			ops.metadata = metadata
+		case !ops.valueSet && ops.metadataSet:
+			value, err := resolveValue(k, precedingUpdates, db)
+			if err != nil {
+				return nil, err
+			}
+			ops.value = value
+		}
+	}
+
+	return txops, nil
+}
+
+// resolveValue returns k's currently-effective value from precedingUpdates, falling back to db
+func resolveValue(k compositeKey, precedingUpdates *PubAndHashUpdates, db privacyenabledstate.DB) ([]byte, error) {
+	if k.coll == "" {
+		if vv, ok := precedingUpdates.PubUpdates.Get(k.ns, k.key); ok {
+			return versionedValueBytes(vv), nil
+		}
+		vv, err := db.GetState(k.ns, k.key)
+		if err != nil {
+			return nil, err
+		}
+		return versionedValueBytes(vv), nil
+	}
+	keyHash := []byte(k.key)
+	if vv, ok := precedingUpdates.HashUpdates.Get(k.ns, k.coll, keyHash); ok {
+		return versionedValueBytes(vv), nil
+	}
+	vv, err := db.GetValueHash(k.ns, k.coll, keyHash)
+	if err != nil {
+		return nil, err
+	}
+	return versionedValueBytes(vv), nil
+}
+
+// resolveMetadata returns k's currently-effective metadata from precedingUpdates, falling back to db
+func resolveMetadata(k compositeKey, precedingUpdates *PubAndHashUpdates, db privacyenabledstate.DB) ([]byte, error) {
+	if k.coll == "" {
+		if vv, ok := precedingUpdates.PubUpdates.Get(k.ns, k.key); ok {
+			return versionedMetadataBytes(vv), nil
+		}
+		return db.GetStateMetadata(k.ns, k.key)
+	}
+	keyHash := []byte(k.key)
+	if vv, ok := precedingUpdates.HashUpdates.Get(k.ns, k.coll, keyHash); ok {
+		return versionedMetadataBytes(vv), nil
+	}
+	return db.GetPrivateDataMetadataByHash(k.ns, k.coll, keyHash)
+}
+
+func versionedValueBytes(vv *statedb.VersionedValue) []byte {
+	if vv == nil {
+		return nil
+	}
+	return vv.Value
+}
+
+func versionedMetadataBytes(vv *statedb.VersionedValue) []byte {
+	if vv == nil {
+		return nil
+	}
+	return vv.Metadata
+}
+
+// serializeMetadataEntries converts the name/value metadata entries present
+// in a rwset.KVMetadataWrite into the serialized form expected by
+// the update batches' PutValAndMetadata and PutValHashAndMetadata methods. A write with no entries
+// clears the metadata, represented here as nil bytes.
+func serializeMetadataEntries(entries []*kvrwset.KVMetadataEntry) ([]byte, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	metadata := &statemetadata.Metadata{}
+	for _, e := range entries {
+		metadata.Entries = append(metadata.Entries, statemetadata.Entry{Name: e.Name, Value: e.Value})
+	}
+	return metadata.Bytes()
+}
@@ -0,0 +1,191 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package validation
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric/core/ledger/internal/version"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/privacyenabledstate"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/statedb"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/statedb/statemetadata"
+	"github.com/stretchr/testify/require"
+)
+
+// committedStateDB is a privacyenabledstate.DB test double that serves a
+// fixed, pre-seeded view of committed state; it embeds the interface so that
+// only the handful of methods prepareTxOps actually calls need overriding.
+type committedStateDB struct {
+	privacyenabledstate.DB
+
+	values   map[compositeKey][]byte
+	metadata map[compositeKey][]byte
+}
+
+func newCommittedStateDB() *committedStateDB {
+	return &committedStateDB{
+		values:   map[compositeKey][]byte{},
+		metadata: map[compositeKey][]byte{},
+	}
+}
+
+func (d *committedStateDB) GetState(ns, key string) (*statedb.VersionedValue, error) {
+	return d.versionedValue(compositeKey{ns: ns, key: key})
+}
+
+func (d *committedStateDB) GetStateMetadata(ns, key string) ([]byte, error) {
+	return d.metadata[compositeKey{ns: ns, key: key}], nil
+}
+
+func (d *committedStateDB) GetValueHash(ns, coll string, keyHash []byte) (*statedb.VersionedValue, error) {
+	return d.versionedValue(compositeKey{ns: ns, coll: coll, key: string(keyHash)})
+}
+
+func (d *committedStateDB) GetPrivateDataMetadataByHash(ns, coll string, keyHash []byte) ([]byte, error) {
+	return d.metadata[compositeKey{ns: ns, coll: coll, key: string(keyHash)}], nil
+}
+
+func (d *committedStateDB) versionedValue(k compositeKey) (*statedb.VersionedValue, error) {
+	value, ok := d.values[k]
+	if !ok {
+		return nil, nil
+	}
+	return &statedb.VersionedValue{Value: value}, nil
+}
+
+func metadataBytes(t *testing.T, names ...string) []byte {
+	metadata := &statemetadata.Metadata{}
+	for _, name := range names {
+		metadata.Entries = append(metadata.Entries, statemetadata.Entry{Name: name, Value: []byte(name + "-value")})
+	}
+	b, err := metadata.Bytes()
+	require.NoError(t, err)
+	return b
+}
+
+func rwsetWithValueOnlyWrite(ns, key string, value []byte) *rwsetutil.TxRwSet {
+	return &rwsetutil.TxRwSet{
+		NsRwSets: []*rwsetutil.NsRwSet{
+			{
+				NameSpace: ns,
+				KvRwSet: &kvrwset.KVRWSet{
+					Writes: []*kvrwset.KVWrite{{Key: key, Value: value}},
+				},
+			},
+		},
+	}
+}
+
+func rwsetWithMetadataOnlyWrite(ns, key string, entries []*kvrwset.KVMetadataEntry) *rwsetutil.TxRwSet {
+	return &rwsetutil.TxRwSet{
+		NsRwSets: []*rwsetutil.NsRwSet{
+			{
+				NameSpace: ns,
+				KvRwSet: &kvrwset.KVRWSet{
+					MetadataWrites: []*kvrwset.KVMetadataWrite{{Key: key, Entries: entries}},
+				},
+			},
+		},
+	}
+}
+
+func TestApplyWriteSetPreservesMetadataFromCommittedState(t *testing.T) {
+	db := newCommittedStateDB()
+	db.metadata[compositeKey{ns: "ns1", key: "key1"}] = metadataBytes(t, "owner")
+
+	u := NewPubAndHashUpdates()
+	err := u.ApplyWriteSet(rwsetWithValueOnlyWrite("ns1", "key1", []byte("value1")), version.NewHeight(1, 0), db, false)
+	require.NoError(t, err)
+
+	vv, ok := u.PubUpdates.Get("ns1", "key1")
+	require.True(t, ok)
+	require.Equal(t, []byte("value1"), vv.Value)
+	require.Equal(t, metadataBytes(t, "owner"), vv.Metadata)
+}
+
+func TestApplyWriteSetPreservesValueAcrossIntraBlockSequence(t *testing.T) {
+	db := newCommittedStateDB()
+	db.values[compositeKey{ns: "ns1", key: "key1"}] = []byte("committed-value")
+
+	u := NewPubAndHashUpdates()
+
+	// tx1 writes only the value.
+	err := u.ApplyWriteSet(rwsetWithValueOnlyWrite("ns1", "key1", []byte("tx1-value")), version.NewHeight(1, 0), db, false)
+	require.NoError(t, err)
+
+	// tx2, later in the same block, writes only the metadata for the same
+	// key; it must not clobber the value tx1 just wrote.
+	entries := []*kvrwset.KVMetadataEntry{{Name: "owner", Value: []byte("owner-value")}}
+	err = u.ApplyWriteSet(rwsetWithMetadataOnlyWrite("ns1", "key1", entries), version.NewHeight(1, 1), db, false)
+	require.NoError(t, err)
+
+	vv, ok := u.PubUpdates.Get("ns1", "key1")
+	require.True(t, ok)
+	require.Equal(t, []byte("tx1-value"), vv.Value, "value written earlier in the block must survive a later metadata-only write")
+	require.Equal(t, metadataBytes(t, "owner"), vv.Metadata)
+}
+
+func TestApplyWriteSetResolvesToNilWhenNothingCommitted(t *testing.T) {
+	db := newCommittedStateDB()
+
+	u := NewPubAndHashUpdates()
+	err := u.ApplyWriteSet(rwsetWithValueOnlyWrite("ns1", "key-never-written-before", []byte("value1")), version.NewHeight(1, 0), db, false)
+	require.NoError(t, err)
+
+	vv, ok := u.PubUpdates.Get("ns1", "key-never-written-before")
+	require.True(t, ok)
+	require.Equal(t, []byte("value1"), vv.Value)
+	require.Nil(t, vv.Metadata, "metadata resolved against a key with no committed metadata and no preceding write must be nil")
+}
+
+func blockWithPvtRwSetHash(txID string, ns, coll string, hash []byte) *Block {
+	return &Block{
+		Num: 1,
+		Txs: []*Transaction{
+			{
+				ID: txID,
+				RWSet: &rwsetutil.TxRwSet{
+					NsRwSets: []*rwsetutil.NsRwSet{
+						{
+							NameSpace: ns,
+							CollHashedRwSets: []*rwsetutil.CollHashedRwSet{
+								{CollectionName: coll, PvtRwSetHash: hash},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestBlockValidateWithMatchingHash(t *testing.T) {
+	pvtData := []byte("pvt-data")
+	hashFunc := func(data []byte) []byte { return []byte("hash-of-" + string(data)) }
+
+	block := blockWithPvtRwSetHash("tx1", "ns1", "coll1", hashFunc(pvtData))
+	err := block.Validate(map[string]map[string][]byte{"ns1": {"coll1": pvtData}}, hashFunc)
+	require.NoError(t, err)
+}
+
+func TestBlockValidateWithMismatchedHashPopulatesStructuredFields(t *testing.T) {
+	hashFunc := func(data []byte) []byte { return []byte("hash-of-" + string(data)) }
+
+	block := blockWithPvtRwSetHash("tx1", "ns1", "coll1", []byte("stale-hash"))
+	err := block.Validate(map[string]map[string][]byte{"ns1": {"coll1": []byte("pvt-data")}}, hashFunc)
+	require.Error(t, err)
+
+	mismatchErr, ok := err.(*ErrPvtdataHashMissmatch)
+	require.True(t, ok)
+	require.Equal(t, "ns1", mismatchErr.Namespace)
+	require.Equal(t, "coll1", mismatchErr.Collection)
+	require.Equal(t, "tx1", mismatchErr.TxID)
+	require.Equal(t, []byte("stale-hash"), mismatchErr.ExpectedHash)
+	require.Equal(t, hashFunc([]byte("pvt-data")), mismatchErr.ActualHash)
+}